@@ -0,0 +1,150 @@
+package model
+
+// DependencyGraph is a reusable, fully-expanded view of a Project's
+// requires/depends_on edges, built once per Project and queried repeatedly
+// instead of re-walking the project YAML (via FindTaskForVariant/
+// FindTasksForVariant) on every call. It stores forward edges, reverse
+// edges, and task-group expansions in adjacency lists keyed by TVPair.
+type DependencyGraph struct {
+	project *Project
+	forward map[TVPair][]TVPair
+	reverse map[TVPair][]TVPair
+}
+
+// NewDependencyGraph builds a DependencyGraph for the given project by
+// expanding every build variant task unit's task-group membership,
+// requirements, and dependencies exactly once.
+func NewDependencyGraph(p *Project) *DependencyGraph {
+	di := &dependencyIncluder{Project: p}
+	g := &DependencyGraph{
+		project: p,
+		forward: map[TVPair][]TVPair{},
+		reverse: map[TVPair][]TVPair{},
+	}
+
+	for _, v := range p.FindAllVariants() {
+		for _, t := range p.FindTasksForVariant(v) {
+			pair := TVPair{TaskName: t, Variant: v}
+			if _, ok := g.forward[pair]; ok {
+				continue
+			}
+
+			var edges []TVPair
+			if tg := p.FindTaskGroup(t); tg != nil {
+				for _, tgt := range tg.Tasks {
+					edges = append(edges, TVPair{TaskName: tgt, Variant: v})
+				}
+			} else if bvt := p.FindTaskForVariant(t, v); bvt != nil {
+				// use expandAllDependencies rather than expandDependencies:
+				// this graph represents the project's true dependency
+				// structure, and patch_optional/omit_on_patch only affect
+				// what a given patch auto-selects, not what mainline runs.
+				edges = append(edges, di.expandRequirements(pair, bvt.Requires)...)
+				edges = append(edges, di.expandAllDependencies(pair, bvt.DependsOn)...)
+			}
+
+			g.forward[pair] = edges
+			for _, dep := range edges {
+				g.reverse[dep] = append(g.reverse[dep], pair)
+			}
+		}
+	}
+
+	return g
+}
+
+// Ancestors returns every TVPair that pair transitively requires or depends on.
+func (g *DependencyGraph) Ancestors(pair TVPair) []TVPair {
+	return g.walk(pair, g.forward)
+}
+
+// Descendants returns every TVPair that transitively requires or depends on pair.
+func (g *DependencyGraph) Descendants(pair TVPair) []TVPair {
+	return g.walk(pair, g.reverse)
+}
+
+// TransitiveClosure returns pairs together with every one of their ancestors,
+// deduplicated.
+func (g *DependencyGraph) TransitiveClosure(pairs []TVPair) []TVPair {
+	seen := map[TVPair]bool{}
+	out := []TVPair{}
+	add := func(pair TVPair) {
+		if !seen[pair] {
+			seen[pair] = true
+			out = append(out, pair)
+		}
+	}
+
+	for _, pair := range pairs {
+		add(pair)
+		for _, ancestor := range g.Ancestors(pair) {
+			add(ancestor)
+		}
+	}
+	return out
+}
+
+// AffectedBy returns every TVPair whose task is directly triggered by
+// changedFiles, plus everything that transitively depends on those tasks, so
+// change-based selection can schedule exactly what a diff could break
+// without re-walking the project YAML.
+func (g *DependencyGraph) AffectedBy(changedFiles []string) []TVPair {
+	seen := map[TVPair]bool{}
+	out := []TVPair{}
+	add := func(pair TVPair) {
+		if !seen[pair] {
+			seen[pair] = true
+			out = append(out, pair)
+		}
+	}
+
+	for _, pair := range g.project.FindTasksForFiles(changedFiles) {
+		add(pair)
+		for _, descendant := range g.Descendants(pair) {
+			add(descendant)
+		}
+	}
+	return out
+}
+
+// walk performs a breadth-first search over adj starting from pair's direct
+// edges, returning every reachable TVPair without including pair itself.
+func (g *DependencyGraph) walk(pair TVPair, adj map[TVPair][]TVPair) []TVPair {
+	seen := map[TVPair]bool{pair: true}
+	queue := append([]TVPair{}, adj[pair]...)
+	out := []TVPair{}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		out = append(out, next)
+		queue = append(queue, adj[next]...)
+	}
+	return out
+}
+
+// DependencyGraphEdge is the persisted form of one DependencyGraph adjacency
+// entry.
+type DependencyGraphEdge struct {
+	From TVPair   `bson:"from" json:"from"`
+	To   []TVPair `bson:"to" json:"to"`
+}
+
+// SerializedDependencyGraph is the persisted form of a DependencyGraph: a
+// flat list of edges, since a map[TVPair][]TVPair doesn't survive a BSON
+// round trip on its own.
+type SerializedDependencyGraph struct {
+	Edges []DependencyGraphEdge `bson:"edges" json:"edges"`
+}
+
+// Serialize converts the graph into its persisted form.
+func (g *DependencyGraph) Serialize() SerializedDependencyGraph {
+	edges := make([]DependencyGraphEdge, 0, len(g.forward))
+	for from, to := range g.forward {
+		edges = append(edges, DependencyGraphEdge{From: from, To: to})
+	}
+	return SerializedDependencyGraph{Edges: edges}
+}
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"github.com/evergreen-ci/evergreen/model/patch"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SetDependencyGraph computes p's DependencyGraph, serializes it onto
+// patchDoc in memory, and persists that same document to the patch's
+// DependencyGraphKey field.
+func (p *Project) SetDependencyGraph(patchDoc *patch.Patch) error {
+	raw, err := bson.Marshal(NewDependencyGraph(p).Serialize())
+	if err != nil {
+		return errors.Wrap(err, "marshalling dependency graph")
+	}
+
+	var asDoc bson.M
+	if err := bson.Unmarshal(raw, &asDoc); err != nil {
+		return errors.Wrap(err, "unmarshalling dependency graph")
+	}
+
+	patchDoc.DependencyGraph = asDoc
+	return errors.Wrap(
+		patch.UpdateOne(patch.ById(patchDoc.Id), bson.M{"$set": bson.M{patch.DependencyGraphKey: asDoc}}),
+		"persisting dependency graph",
+	)
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestGraph builds a DependencyGraph directly from forward/reverse
+// adjacency, bypassing NewDependencyGraph so these tests don't need a real
+// *Project.
+func newTestGraph(forward map[TVPair][]TVPair) *DependencyGraph {
+	g := &DependencyGraph{
+		forward: forward,
+		reverse: map[TVPair][]TVPair{},
+	}
+	for from, tos := range forward {
+		for _, to := range tos {
+			g.reverse[to] = append(g.reverse[to], from)
+		}
+	}
+	return g
+}
+
+func TestDependencyGraphAncestorsAndDescendants(t *testing.T) {
+	compile := TVPair{Variant: "v", TaskName: "compile"}
+	test := TVPair{Variant: "v", TaskName: "test"}
+	push := TVPair{Variant: "v", TaskName: "push"}
+
+	g := newTestGraph(map[TVPair][]TVPair{
+		test: {compile},
+		push: {test},
+	})
+
+	assert.ElementsMatch(t, []TVPair{compile}, g.Ancestors(test))
+	assert.ElementsMatch(t, []TVPair{compile, test}, g.Ancestors(push))
+	assert.ElementsMatch(t, []TVPair{push}, g.Descendants(test))
+	assert.ElementsMatch(t, []TVPair{test, push}, g.Descendants(compile))
+}
+
+func TestDependencyGraphTransitiveClosure(t *testing.T) {
+	compile := TVPair{Variant: "v", TaskName: "compile"}
+	test := TVPair{Variant: "v", TaskName: "test"}
+	lint := TVPair{Variant: "v", TaskName: "lint"}
+
+	g := newTestGraph(map[TVPair][]TVPair{
+		test: {compile},
+		lint: {compile},
+	})
+
+	assert.ElementsMatch(t, []TVPair{test, lint, compile}, g.TransitiveClosure([]TVPair{test, lint}))
+}
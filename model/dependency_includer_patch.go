@@ -0,0 +1,17 @@
+package model
+
+import "github.com/evergreen-ci/evergreen/model/patch"
+
+// NewDependencyIncluderForPatch builds a dependencyIncluder for p that
+// honors patchDoc's per-patch dependency overrides, so a patch can opt back
+// into patch_optional dependencies via IncludeOptionalDependencies.
+func NewDependencyIncluderForPatch(p *Project, patchDoc *patch.Patch) *dependencyIncluder {
+	di := &dependencyIncluder{Project: p}
+	for _, pair := range patchDoc.IncludeOptionalDependencies {
+		di.IncludeOptionalDependencies = append(di.IncludeOptionalDependencies, TVPair{
+			TaskName: pair.TaskName,
+			Variant:  pair.Variant,
+		})
+	}
+	return di
+}
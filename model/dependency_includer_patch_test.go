@@ -0,0 +1,26 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/evergreen-ci/evergreen/model/patch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDependencyIncluderForPatchCopiesOverrides(t *testing.T) {
+	patchDoc := &patch.Patch{
+		IncludeOptionalDependencies: []patch.TVPair{
+			{Variant: "ubuntu1604", TaskName: "lint"},
+		},
+	}
+
+	di := NewDependencyIncluderForPatch(&Project{}, patchDoc)
+
+	assert.True(t, di.isOptionalIncluded(TVPair{Variant: "ubuntu1604", TaskName: "lint"}))
+	assert.False(t, di.isOptionalIncluded(TVPair{Variant: "ubuntu1604", TaskName: "compile"}))
+}
+
+func TestIsOptionalIncludedEmptyByDefault(t *testing.T) {
+	di := &dependencyIncluder{}
+	assert.False(t, di.isOptionalIncluded(TVPair{Variant: "v", TaskName: "t"}))
+}
@@ -0,0 +1,139 @@
+package model
+
+// ValidateDependencyGraph walks the fully-expanded task/variant graph for
+// the project and reports every dependency cycle it finds, using Tarjan's
+// strongly connected components algorithm over the same forward adjacency
+// that NewDependencyGraph builds. Unlike Include, which only surfaces
+// cycles reachable from a particular patch's initial selection, this walks
+// every build variant task unit so cycles can be flagged at project-parse
+// time rather than at task-selection time.
+func (p *Project) ValidateDependencyGraph() []DependencyError {
+	graph := NewDependencyGraph(p)
+
+	errs := []DependencyError{}
+	for _, scc := range tarjanSCC(graph.forward) {
+		errs = append(errs, DependencyError{Cycle: findCycle(graph.forward, scc)})
+	}
+	return errs
+}
+
+// findCycle returns one real, edge-following cycle contained entirely
+// within scc, a set of TVPairs already known (via tarjanSCC) to form a
+// strongly connected component. scc's own membership order is the order
+// Tarjan's algorithm popped it off its stack, not a path along real edges,
+// so an SCC with more than one interleaved cycle needs this separate
+// bounded DFS to report a path whose consecutive pairs are actually
+// connected.
+func findCycle(edges map[TVPair][]TVPair, scc []TVPair) []TVPair {
+	members := map[TVPair]bool{}
+	for _, v := range scc {
+		members[v] = true
+	}
+
+	visited := map[TVPair]bool{}
+	onPath := map[TVPair]bool{}
+	var path []TVPair
+
+	var dfs func(v TVPair) []TVPair
+	dfs = func(v TVPair) []TVPair {
+		visited[v] = true
+		onPath[v] = true
+		path = append(path, v)
+
+		for _, w := range edges[v] {
+			if !members[w] {
+				continue
+			}
+			if onPath[w] {
+				start := 0
+				for i, p := range path {
+					if p == w {
+						start = i
+						break
+					}
+				}
+				return append(append([]TVPair{}, path[start:]...), w)
+			}
+			if !visited[w] {
+				if cycle := dfs(w); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		onPath[v] = false
+		return nil
+	}
+
+	return dfs(scc[0])
+}
+
+// tarjanSCC returns the strongly connected components of the graph described
+// by edges, using Tarjan's algorithm. Only components that represent a real
+// cycle are returned: groups of two or more TVPairs, or a single TVPair with
+// a self-loop.
+func tarjanSCC(edges map[TVPair][]TVPair) [][]TVPair {
+	index := 0
+	indices := map[TVPair]int{}
+	lowlink := map[TVPair]int{}
+	onStack := map[TVPair]bool{}
+	stack := []TVPair{}
+	sccs := [][]TVPair{}
+
+	var strongConnect func(v TVPair)
+	strongConnect = func(v TVPair) {
+		indices[v] = index
+		lowlink[v] = index
+		index++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range edges[v] {
+			if _, visited := indices[w]; !visited {
+				strongConnect(w)
+				if lowlink[w] < lowlink[v] {
+					lowlink[v] = lowlink[w]
+				}
+			} else if onStack[w] && indices[w] < lowlink[v] {
+				lowlink[v] = indices[w]
+			}
+		}
+
+		if lowlink[v] != indices[v] {
+			return
+		}
+
+		scc := []TVPair{}
+		for {
+			n := len(stack) - 1
+			w := stack[n]
+			stack = stack[:n]
+			onStack[w] = false
+			scc = append(scc, w)
+			if w == v {
+				break
+			}
+		}
+
+		if len(scc) > 1 || containsSelfLoop(edges[v], v) {
+			sccs = append(sccs, scc)
+		}
+	}
+
+	for v := range edges {
+		if _, visited := indices[v]; !visited {
+			strongConnect(v)
+		}
+	}
+	return sccs
+}
+
+func containsSelfLoop(edges []TVPair, v TVPair) bool {
+	for _, e := range edges {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package model
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sortPairs(pairs []TVPair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Variant != pairs[j].Variant {
+			return pairs[i].Variant < pairs[j].Variant
+		}
+		return pairs[i].TaskName < pairs[j].TaskName
+	})
+}
+
+func TestTarjanSCCFindsNoCyclesInADag(t *testing.T) {
+	a := TVPair{Variant: "v", TaskName: "a"}
+	b := TVPair{Variant: "v", TaskName: "b"}
+	c := TVPair{Variant: "v", TaskName: "c"}
+
+	edges := map[TVPair][]TVPair{
+		a: {b},
+		b: {c},
+		c: {},
+	}
+
+	assert.Empty(t, tarjanSCC(edges))
+}
+
+func TestTarjanSCCFindsACycle(t *testing.T) {
+	a := TVPair{Variant: "v", TaskName: "a"}
+	b := TVPair{Variant: "v", TaskName: "b"}
+	c := TVPair{Variant: "v", TaskName: "c"}
+
+	edges := map[TVPair][]TVPair{
+		a: {b},
+		b: {c},
+		c: {a},
+	}
+
+	sccs := tarjanSCC(edges)
+	if assert.Len(t, sccs, 1) {
+		scc := sccs[0]
+		sortPairs(scc)
+		assert.Equal(t, []TVPair{a, b, c}, scc)
+	}
+}
+
+func TestTarjanSCCFindsASelfLoop(t *testing.T) {
+	a := TVPair{Variant: "v", TaskName: "a"}
+
+	edges := map[TVPair][]TVPair{
+		a: {a},
+	}
+
+	sccs := tarjanSCC(edges)
+	if assert.Len(t, sccs, 1) {
+		assert.Equal(t, []TVPair{a}, sccs[0])
+	}
+}
+
+// assertIsRealCycle fails the test if any consecutive pair in cycle isn't
+// actually connected by an edge in edges.
+func assertIsRealCycle(t *testing.T, edges map[TVPair][]TVPair, cycle []TVPair) {
+	t.Helper()
+	for i := 0; i < len(cycle)-1; i++ {
+		from, to := cycle[i], cycle[i+1]
+		found := false
+		for _, w := range edges[from] {
+			if w == to {
+				found = true
+				break
+			}
+		}
+		assert.Truef(t, found, "no edge %s/%s -> %s/%s", from.Variant, from.TaskName, to.Variant, to.TaskName)
+	}
+}
+
+func TestFindCycleReportsARealPathForASimpleCycle(t *testing.T) {
+	a := TVPair{Variant: "v", TaskName: "a"}
+	b := TVPair{Variant: "v", TaskName: "b"}
+	c := TVPair{Variant: "v", TaskName: "c"}
+
+	edges := map[TVPair][]TVPair{
+		a: {b},
+		b: {c},
+		c: {a},
+	}
+
+	cycle := findCycle(edges, []TVPair{a, b, c})
+	assertIsRealCycle(t, edges, cycle)
+	assert.Equal(t, cycle[0], cycle[len(cycle)-1])
+}
+
+func TestFindCycleReportsARealPathWhenSCCHasInterleavedCycles(t *testing.T) {
+	// a -> b -> c -> a is one cycle; b -> d -> c is another path back into
+	// it, so the SCC {a, b, c, d} contains more than one cycle. Tarjan's
+	// stack-pop order for this SCC doesn't follow real edges (e.g. it never
+	// has an edge c -> b), so findCycle must walk the graph itself rather
+	// than trusting that order.
+	a := TVPair{Variant: "v", TaskName: "a"}
+	b := TVPair{Variant: "v", TaskName: "b"}
+	c := TVPair{Variant: "v", TaskName: "c"}
+	d := TVPair{Variant: "v", TaskName: "d"}
+
+	edges := map[TVPair][]TVPair{
+		a: {b},
+		b: {c, d},
+		c: {a},
+		d: {c},
+	}
+
+	sccs := tarjanSCC(edges)
+	if assert.Len(t, sccs, 1) {
+		cycle := findCycle(edges, sccs[0])
+		assertIsRealCycle(t, edges, cycle)
+		assert.Equal(t, cycle[0], cycle[len(cycle)-1])
+	}
+}
+
+func TestFindCycleReportsASelfLoop(t *testing.T) {
+	a := TVPair{Variant: "v", TaskName: "a"}
+	edges := map[TVPair][]TVPair{a: {a}}
+
+	assert.Equal(t, []TVPair{a, a}, findCycle(edges, []TVPair{a}))
+}
@@ -18,7 +18,8 @@ const (
 )
 
 // BSON fields for the patches
-//nolint: deadcode, megacheck, unused
+//
+//nolint:deadcode,megacheck,unused
 var (
 	IdKey              = bsonutil.MustHaveTag(Patch{}, "Id")
 	DescriptionKey     = bsonutil.MustHaveTag(Patch{}, "Description")
@@ -40,6 +41,14 @@ var (
 	AliasKey           = bsonutil.MustHaveTag(Patch{}, "Alias")
 	githubPatchDataKey = bsonutil.MustHaveTag(Patch{}, "GithubPatchData")
 
+	// IncludeOptionalDependenciesKey stores the TVPairs a patch has opted
+	// back into despite being marked patch_optional in the project.
+	IncludeOptionalDependenciesKey = bsonutil.MustHaveTag(Patch{}, "IncludeOptionalDependencies")
+
+	// DependencyGraphKey stores the bson-marshaled
+	// model.SerializedDependencyGraph computed for this patch.
+	DependencyGraphKey = bsonutil.MustHaveTag(Patch{}, "DependencyGraph")
+
 	// BSON fields for the module patch struct
 	ModulePatchNameKey    = bsonutil.MustHaveTag(ModulePatch{}, "ModuleName")
 	ModulePatchGithashKey = bsonutil.MustHaveTag(ModulePatch{}, "Githash")
@@ -102,6 +111,79 @@ func ByUserPaginated(user string, ts time.Time, limit int) db.Q {
 	}).Sort([]string{"-" + CreateTimeKey}).Limit(limit)
 }
 
+// ByUserWithOptionalDependencies produces a query that returns patches by
+// the given user that have opted into at least one patch_optional
+// dependency via IncludeOptionalDependencies.
+func ByUserWithOptionalDependencies(user string) db.Q {
+	return db.Query(bson.M{
+		AuthorKey: user,
+		IncludeOptionalDependenciesKey: bson.M{
+			"$exists": true,
+			"$ne":     bson.A{},
+		},
+	})
+}
+
+// BackfillIncludeOptionalDependencies sets IncludeOptionalDependencies to
+// its zero value on any patch documents that predate the field, so callers
+// can rely on it always being present rather than treating a missing key
+// as null.
+func BackfillIncludeOptionalDependencies() error {
+	_, err := UpdateAll(
+		bson.M{IncludeOptionalDependenciesKey: bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{IncludeOptionalDependenciesKey: []TVPair{}}},
+	)
+	return errors.Wrap(err, "backfilling include_optional_dependencies")
+}
+
+// BackfillDependencyGraph sets DependencyGraph to its zero value on any
+// patch documents that predate the field, so callers can rely on it always
+// being present rather than treating a missing key as null.
+func BackfillDependencyGraph() error {
+	_, err := UpdateAll(
+		bson.M{DependencyGraphKey: bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{DependencyGraphKey: bson.M{}}},
+	)
+	return errors.Wrap(err, "backfilling dependency_graph")
+}
+
+// moduleGithashFilter builds the filter for ByModuleGithash. It's split out
+// from the db.Q wrapper so the array-matching shape can be asserted on
+// directly in tests, without a live collection to query against.
+func moduleGithashFilter(module, githash string) bson.M {
+	return bson.M{
+		PatchesKey: bson.M{
+			"$elemMatch": bson.M{
+				ModulePatchNameKey:    module,
+				ModulePatchGithashKey: githash,
+			},
+		},
+	}
+}
+
+// ByModuleGithash produces a query that returns patches with a single
+// ModulePatch entry matching both the given module and githash. $elemMatch
+// is required here: giving the two conditions as sibling keys would let
+// Mongo satisfy them against different elements of the Patches array.
+func ByModuleGithash(module, githash string) db.Q {
+	return db.Query(moduleGithashFilter(module, githash))
+}
+
+// authorAcrossModulesFilter builds the filter for ByAuthorAcrossModules;
+// split out for the same reason as moduleGithashFilter.
+func authorAcrossModulesFilter(user string) bson.M {
+	return bson.M{
+		AuthorKey:         user,
+		PatchesKey + ".0": bson.M{"$exists": true},
+	}
+}
+
+// ByAuthorAcrossModules produces a query that returns patches authored by
+// user that have at least one module patch attached.
+func ByAuthorAcrossModules(user string) db.Q {
+	return db.Query(authorAcrossModulesFilter(user))
+}
+
 // ByUserProjectAndGitspec produces a query that returns patches by the given
 // patch author, project, and gitspec.
 func ByUserProjectAndGitspec(user string, project string, gitspec string) db.Q {
@@ -221,3 +303,55 @@ func FindProjectForPatch(patchID mgobson.ObjectId) (string, error) {
 	}
 	return p.Project, nil
 }
+
+// MergedPatch is a flattened view produced by FindMergedPatchesByUser: a
+// Patch document whose Patches array has been unwound to a single
+// ModulePatch, so a module's githash or diff summary can be matched
+// directly without a client-side scan. Patch isn't embedded here because
+// its own Patches field (bson "patches") would collide with the unwound
+// "patches" key, which is a single ModulePatch rather than a slice once
+// $unwind has run.
+type MergedPatch struct {
+	Id          mgobson.ObjectId `bson:"_id,omitempty"`
+	Description string           `bson:"desc"`
+	Project     string           `bson:"branch"`
+	Githash     string           `bson:"githash"`
+	PatchNumber int              `bson:"patch_number"`
+	Author      string           `bson:"author"`
+	Version     string           `bson:"version"`
+	Status      string           `bson:"status"`
+	CreateTime  time.Time        `bson:"create_time"`
+	ModulePatch ModulePatch      `bson:"patches"`
+}
+
+// mergedPatchesByUserPipeline builds the aggregation pipeline for
+// FindMergedPatchesByUser; split out so the $match/$unwind/$project shape
+// can be asserted on directly in tests, without a live collection to
+// aggregate against.
+func mergedPatchesByUserPipeline(user string, includeDiff bool) []bson.M {
+	pipeline := []bson.M{
+		{"$match": bson.M{AuthorKey: user}},
+		{"$unwind": "$" + PatchesKey},
+	}
+	if !includeDiff {
+		pipeline = append(pipeline, bson.M{
+			"$project": bson.M{
+				bsonutil.GetDottedKeyName(PatchesKey, ModulePatchSetKey, PatchSetPatchKey): 0,
+			},
+		})
+	}
+	return pipeline
+}
+
+// FindMergedPatchesByUser runs an aggregation that unwinds each of the
+// user's patches' ModulePatch entries and flattens them alongside their
+// parent patch, giving module-heavy projects a single-round-trip lookup
+// path instead of fetching every patch and scanning its Patches client-side.
+// Diff bodies are excluded from the result unless includeDiff is set.
+func FindMergedPatchesByUser(user string, includeDiff bool) ([]MergedPatch, error) {
+	merged := []MergedPatch{}
+	if err := db.Aggregate(Collection, mergedPatchesByUserPipeline(user, includeDiff), &merged); err != nil {
+		return nil, errors.Wrap(err, "aggregating merged patches by user")
+	}
+	return merged, nil
+}
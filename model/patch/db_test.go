@@ -0,0 +1,71 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestModuleGithashFilterMatchesBothFieldsOnTheSameElement(t *testing.T) {
+	filter := moduleGithashFilter("enterprise", "abc123")
+
+	assert.Equal(t, bson.M{
+		PatchesKey: bson.M{
+			"$elemMatch": bson.M{
+				ModulePatchNameKey:    "enterprise",
+				ModulePatchGithashKey: "abc123",
+			},
+		},
+	}, filter)
+}
+
+func TestAuthorAcrossModulesFilterRequiresANonEmptyPatchesArray(t *testing.T) {
+	filter := authorAcrossModulesFilter("me")
+
+	assert.Equal(t, bson.M{
+		AuthorKey:         "me",
+		PatchesKey + ".0": bson.M{"$exists": true},
+	}, filter)
+}
+
+func TestMergedPatchesByUserPipelineExcludesDiffByDefault(t *testing.T) {
+	pipeline := mergedPatchesByUserPipeline("me", false)
+
+	assert.Equal(t, []bson.M{
+		{"$match": bson.M{AuthorKey: "me"}},
+		{"$unwind": "$" + PatchesKey},
+		{"$project": bson.M{
+			PatchesKey + "." + ModulePatchSetKey + "." + PatchSetPatchKey: 0,
+		}},
+	}, pipeline)
+}
+
+func TestMergedPatchesByUserPipelineKeepsDiffWhenRequested(t *testing.T) {
+	pipeline := mergedPatchesByUserPipeline("me", true)
+
+	assert.Equal(t, []bson.M{
+		{"$match": bson.M{AuthorKey: "me"}},
+		{"$unwind": "$" + PatchesKey},
+	}, pipeline)
+}
+
+// TestMergedPatchTagsDontCollide guards against the bug the original
+// MergedPatch shipped with: embedding Patch inline alongside an explicit
+// ModulePatch field collided on the "patches" bson tag, since Patch itself
+// has a Patches field with that tag. A round trip through the real bson
+// codec is the most direct way to catch that regression.
+func TestMergedPatchTagsDontCollide(t *testing.T) {
+	mp := MergedPatch{
+		Id:          NewId("5ca0944773e6010810d71fba"),
+		Author:      "me",
+		ModulePatch: ModulePatch{ModuleName: "enterprise", Githash: "abc123"},
+	}
+
+	raw, err := bson.Marshal(mp)
+	assert.NoError(t, err)
+
+	var decoded MergedPatch
+	assert.NoError(t, bson.Unmarshal(raw, &decoded))
+	assert.Equal(t, mp, decoded)
+}
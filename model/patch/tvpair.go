@@ -0,0 +1,10 @@
+package patch
+
+// TVPair identifies a single task on a single build variant within a
+// patch's dependency overrides. It mirrors model.TVPair's shape; this
+// package stores its own copy rather than importing model, since model
+// already imports patch.
+type TVPair struct {
+	Variant  string `bson:"variant" json:"variant"`
+	TaskName string `bson:"task_name" json:"task_name"`
+}
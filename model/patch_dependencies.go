@@ -1,20 +1,57 @@
 package model
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/mongodb/grip"
 )
 
 type dependencyIncluder struct {
 	Project  *Project
 	included map[TVPair]bool
+
+	// visiting tracks the TVPairs currently on the DFS stack for the
+	// in-progress Include call, so a re-entrant handle() call can be
+	// recognized as a dependency cycle rather than silently memoized.
+	visiting map[TVPair]bool
+	stack    []TVPair
+	errors   []DependencyError
+
+	// IncludeOptionalDependencies lists TVPairs that this patch has opted
+	// back into even though their project definition marks them
+	// patch_optional, letting a single patch override the project's default
+	// soft-dependency behavior.
+	IncludeOptionalDependencies []TVPair
+}
+
+// DependencyError describes an invalid dependency configuration discovered
+// while expanding a task/variant's dependency graph, such as a cycle that
+// can never be satisfied.
+type DependencyError struct {
+	// Cycle holds the ordered TVPairs that form the loop, starting and
+	// ending with the same pair.
+	Cycle []TVPair
+}
+
+func (e DependencyError) Error() string {
+	pairs := make([]string, 0, len(e.Cycle))
+	for _, p := range e.Cycle {
+		pairs = append(pairs, fmt.Sprintf("%s/%s", p.Variant, p.TaskName))
+	}
+	return fmt.Sprintf("dependency cycle detected: %s", strings.Join(pairs, " -> "))
 }
 
 // Include crawls the tasks represented by the combination of variants and tasks and
 // add or removes tasks based on the dependency graph. Required and dependent tasks
 // are added; tasks that depend on unpatchable tasks are pruned. New slices
-// of variants and tasks are returned.
-func (di *dependencyIncluder) Include(initialDeps []TVPair) []TVPair {
+// of variants and tasks are returned, along with any dependency cycles found
+// while expanding the graph.
+func (di *dependencyIncluder) Include(initialDeps []TVPair) ([]TVPair, []DependencyError) {
 	di.included = map[TVPair]bool{}
+	di.visiting = map[TVPair]bool{}
+	di.stack = nil
+	di.errors = nil
 
 	// handle each pairing, recursively adding and pruning based
 	// on the task's requirements and dependencies
@@ -28,13 +65,59 @@ func (di *dependencyIncluder) Include(initialDeps []TVPair) []TVPair {
 			outPairs = append(outPairs, pair)
 		}
 	}
-	return outPairs
+	return outPairs, di.errors
+}
+
+// recordCycle records a DependencyError for the loop formed between pair and
+// its first occurrence on the current DFS stack.
+func (di *dependencyIncluder) recordCycle(pair TVPair) {
+	start := 0
+	for i, p := range di.stack {
+		if p == pair {
+			start = i
+			break
+		}
+	}
+	cycle := append([]TVPair{}, di.stack[start:]...)
+	cycle = append(cycle, pair)
+	di.errors = append(di.errors, DependencyError{Cycle: cycle})
+}
+
+// dfsEnter marks pair as active on the current Include DFS stack and
+// reports whether it was already active. A pair that's already active
+// means handle re-entered it before returning, i.e. a dependency cycle;
+// dfsEnter records that cycle via recordCycle before reporting it.
+// Callers that get false back must call dfsExit(pair) once they're done
+// expanding pair's dependencies.
+func (di *dependencyIncluder) dfsEnter(pair TVPair) bool {
+	if di.visiting[pair] {
+		di.recordCycle(pair)
+		return true
+	}
+	if di.visiting == nil {
+		di.visiting = map[TVPair]bool{}
+	}
+	di.visiting[pair] = true
+	di.stack = append(di.stack, pair)
+	return false
+}
+
+// dfsExit unmarks pair as active on the current Include DFS stack.
+func (di *dependencyIncluder) dfsExit(pair TVPair) {
+	delete(di.visiting, pair)
+	di.stack = di.stack[:len(di.stack)-1]
 }
 
 // handle finds and includes all tasks that the given task/variant pair
 // requires or depends on. Returns true if the task and all of its
 // dependent/required tasks are patchable, false if they are not.
 func (di *dependencyIncluder) handle(pair TVPair) bool {
+	if di.dfsEnter(pair) {
+		di.included[pair] = false
+		return false
+	}
+	defer di.dfsExit(pair)
+
 	if included, ok := di.included[pair]; ok {
 		// we've been here before, so don't redo work
 		return included
@@ -110,52 +193,102 @@ func (di *dependencyIncluder) expandRequirements(pair TVPair, reqs []TaskUnitReq
 func (di *dependencyIncluder) expandDependencies(pair TVPair, depends []TaskUnitDependency) []TVPair {
 	deps := []TVPair{}
 	for _, d := range depends {
-		// don't automatically add dependencies if they are marked patch_optional
-		if d.PatchOptional {
+		// the project has marked this dependency as unsafe to pull into patch
+		// builds, so it is never auto-added regardless of PatchOptional or
+		// this patch's IncludeOptionalDependencies overrides.
+		if d.OmitOnPatch {
 			continue
 		}
-		switch {
-		case d.Variant == AllVariants && d.Name == AllDependencies: // task = *, variant = *
-			// Here we get all variants and tasks (excluding the current task)
-			// and add them to the list of tasks and variants.
-			for _, v := range di.Project.FindAllVariants() {
-				for _, t := range di.Project.FindTasksForVariant(v) {
-					if !(t == pair.TaskName && v == pair.Variant) {
-						deps = append(deps, TVPair{TaskName: t, Variant: v})
-					}
-				}
-			}
 
-		case d.Variant == AllVariants: // specific task, variant = *
-			// In the case where we depend on a task on all variants, we fetch the task's
-			// dependencies, then add that task for all variants that have it.
-			for _, v := range di.Project.FindVariantsWithTask(d.Name) {
-				if !(pair.TaskName == d.Name && pair.Variant == v) {
-					deps = append(deps, TVPair{TaskName: d.Name, Variant: v})
+		resolved := di.resolveDependencyPairs(pair, d)
+
+		if d.PatchOptional {
+			// don't automatically add dependencies marked patch_optional,
+			// unless this patch explicitly opted back into them.
+			for _, dep := range resolved {
+				if di.isOptionalIncluded(dep) {
+					deps = append(deps, dep)
 				}
 			}
+			continue
+		}
 
-		case d.Name == AllDependencies: // task = *, specific variant
-			// Here we add every task for a single variant. We add the dependent variant,
-			// then add all of that variant's task, as well as their dependencies.
-			v := d.Variant
-			if v == "" {
-				v = pair.Variant
-			}
+		deps = append(deps, resolved...)
+	}
+	return deps
+}
+
+// expandAllDependencies finds every task depended on by the current
+// task/variant pair, ignoring PatchOptional and OmitOnPatch. Those two
+// fields are patch-selection heuristics only: a mainline build still runs
+// patch_optional and omit_on_patch dependencies for real, so callers that
+// need the project's true, patch-agnostic dependency graph (as opposed to
+// what a given patch would auto-select) must use this instead of
+// expandDependencies.
+func (di *dependencyIncluder) expandAllDependencies(pair TVPair, depends []TaskUnitDependency) []TVPair {
+	deps := []TVPair{}
+	for _, d := range depends {
+		deps = append(deps, di.resolveDependencyPairs(pair, d)...)
+	}
+	return deps
+}
+
+// resolveDependencyPairs expands a single TaskUnitDependency into the
+// concrete TVPairs it refers to, handling the "*" task/variant wildcards.
+func (di *dependencyIncluder) resolveDependencyPairs(pair TVPair, d TaskUnitDependency) []TVPair {
+	deps := []TVPair{}
+	switch {
+	case d.Variant == AllVariants && d.Name == AllDependencies: // task = *, variant = *
+		// Here we get all variants and tasks (excluding the current task)
+		// and add them to the list of tasks and variants.
+		for _, v := range di.Project.FindAllVariants() {
 			for _, t := range di.Project.FindTasksForVariant(v) {
-				if !(pair.TaskName == t && pair.Variant == v) {
+				if !(t == pair.TaskName && v == pair.Variant) {
 					deps = append(deps, TVPair{TaskName: t, Variant: v})
 				}
 			}
+		}
 
-		default: // specific name, specific variant
-			// We simply add a single task/variant and its dependencies.
-			v := d.Variant
-			if v == "" {
-				v = pair.Variant
+	case d.Variant == AllVariants: // specific task, variant = *
+		// In the case where we depend on a task on all variants, we fetch the task's
+		// dependencies, then add that task for all variants that have it.
+		for _, v := range di.Project.FindVariantsWithTask(d.Name) {
+			if !(pair.TaskName == d.Name && pair.Variant == v) {
+				deps = append(deps, TVPair{TaskName: d.Name, Variant: v})
+			}
+		}
+
+	case d.Name == AllDependencies: // task = *, specific variant
+		// Here we add every task for a single variant. We add the dependent variant,
+		// then add all of that variant's task, as well as their dependencies.
+		v := d.Variant
+		if v == "" {
+			v = pair.Variant
+		}
+		for _, t := range di.Project.FindTasksForVariant(v) {
+			if !(pair.TaskName == t && pair.Variant == v) {
+				deps = append(deps, TVPair{TaskName: t, Variant: v})
 			}
-			deps = append(deps, TVPair{TaskName: d.Name, Variant: v})
 		}
+
+	default: // specific name, specific variant
+		// We simply add a single task/variant and its dependencies.
+		v := d.Variant
+		if v == "" {
+			v = pair.Variant
+		}
+		deps = append(deps, TVPair{TaskName: d.Name, Variant: v})
 	}
 	return deps
 }
+
+// isOptionalIncluded returns true if pair was explicitly opted back in via
+// IncludeOptionalDependencies, overriding its patch_optional dependency.
+func (di *dependencyIncluder) isOptionalIncluded(pair TVPair) bool {
+	for _, p := range di.IncludeOptionalDependencies {
+		if p == pair {
+			return true
+		}
+	}
+	return false
+}
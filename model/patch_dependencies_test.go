@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencyErrorFormatsCycleAsAPath(t *testing.T) {
+	err := DependencyError{Cycle: []TVPair{
+		{Variant: "v", TaskName: "a"},
+		{Variant: "v", TaskName: "b"},
+		{Variant: "v", TaskName: "a"},
+	}}
+
+	assert.Equal(t, "dependency cycle detected: v/a -> v/b -> v/a", err.Error())
+}
+
+// TestDFSEnterDetectsReentry exercises the visiting/stack bookkeeping that
+// handle relies on to turn a re-entrant handle call into a reported
+// DependencyError rather than infinite recursion. handle itself always looks
+// tasks up through a *Project, so this drives dfsEnter/dfsExit directly
+// instead.
+func TestDFSEnterDetectsReentry(t *testing.T) {
+	di := &dependencyIncluder{included: map[TVPair]bool{}}
+
+	a := TVPair{Variant: "v", TaskName: "a"}
+	b := TVPair{Variant: "v", TaskName: "b"}
+
+	assert.False(t, di.dfsEnter(a))
+	assert.False(t, di.dfsEnter(b))
+	assert.True(t, di.dfsEnter(a))
+
+	if assert.Len(t, di.errors, 1) {
+		assert.Equal(t, []TVPair{a, b, a}, di.errors[0].Cycle)
+	}
+}
+
+func TestDFSExitAllowsReenteringAPairOnceItsPopped(t *testing.T) {
+	di := &dependencyIncluder{included: map[TVPair]bool{}}
+
+	a := TVPair{Variant: "v", TaskName: "a"}
+
+	assert.False(t, di.dfsEnter(a))
+	di.dfsExit(a)
+	assert.False(t, di.dfsEnter(a))
+	assert.Empty(t, di.errors)
+}